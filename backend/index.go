@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const indexPath = "./thumbs/index.json"
+
+// sweepInterval is how often the background sweeper checks for thumbnails
+// whose source file has been deleted.
+const sweepInterval = 10 * time.Minute
+
+// VariantStamp records the source file's mtime/size at the moment a given
+// generated variant (a thumbnail, an HLS segment, ...) was produced, so
+// needsRegen can tell that one specific file apart from the rest of a
+// source's variants that may already have been regenerated.
+type VariantStamp struct {
+	ModTime time.Time `json:"modTime"`
+	Size    int64     `json:"size"`
+}
+
+// IndexEntry records what we know about a source file: a SHA1 for
+// verification, its EXIF metadata, and a per-variant-path freshness stamp
+// so each generated file (thumbnail size/format, animated preview, HLS
+// segment, ...) can be judged stale independently of its siblings.
+type IndexEntry struct {
+	SHA1     string                  `json:"sha1,omitempty"`
+	Metadata ImageMetadata           `json:"metadata,omitempty"`
+	Variants map[string]VariantStamp `json:"variants"`
+}
+
+// ImageMetadata is info extracted while generating a thumbnail. For images
+// it holds the EXIF fields (see readExif), the source dimensions and a
+// Blurhash; for videos only the dimensions of the extracted frame and a
+// Blurhash are set. Zero value means nothing could be extracted.
+type ImageMetadata struct {
+	DateTimeOriginal time.Time `json:"dateTimeOriginal,omitempty"`
+	GPSLat           float64   `json:"gpsLat,omitempty"`
+	GPSLng           float64   `json:"gpsLng,omitempty"`
+	Camera           string    `json:"camera,omitempty"`
+	Width            int       `json:"width,omitempty"`
+	Height           int       `json:"height,omitempty"`
+	// Blurhash is a short (~30 byte) placeholder string the frontend can
+	// decode and render immediately while the real thumbnail loads.
+	Blurhash string `json:"blurhash,omitempty"`
+}
+
+func (m ImageMetadata) isZero() bool {
+	return m.Width == 0 && m.Height == 0 && m.Camera == "" && m.DateTimeOriginal.IsZero()
+}
+
+// ThumbIndex is a JSON-backed index of generated thumbnails keyed by
+// source path (relative to imagesDir).
+type ThumbIndex struct {
+	mu      sync.RWMutex
+	path    string
+	Entries map[string]*IndexEntry `json:"entries"`
+}
+
+// loadIndex reads the index from path, starting fresh if it doesn't exist
+// or fails to parse.
+func loadIndex(path string) *ThumbIndex {
+	idx := &ThumbIndex{path: path, Entries: map[string]*IndexEntry{}}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("failed to read thumb index, starting fresh: %v", err)
+		}
+		return idx
+	}
+	if err := json.Unmarshal(data, idx); err != nil {
+		log.Printf("failed to parse thumb index, starting fresh: %v", err)
+		idx.Entries = map[string]*IndexEntry{}
+	}
+	return idx
+}
+
+// save persists the index to disk. Callers must hold mu.
+func (idx *ThumbIndex) save() error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(idx.path), 0o755)
+	return os.WriteFile(idx.path, data, 0o644)
+}
+
+// fresh reports whether thumbPath was generated from rel's current
+// mtime/size, i.e. the source hasn't changed since that specific variant
+// was produced. Other variants of the same source may be stale or fresh
+// independently of this one.
+func (idx *ThumbIndex) fresh(thumbPath, rel string, fi os.FileInfo) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	entry, ok := idx.Entries[rel]
+	if !ok {
+		return false
+	}
+	stamp, ok := entry.Variants[thumbPath]
+	if !ok {
+		return false
+	}
+	return stamp.Size == fi.Size() && stamp.ModTime.Equal(fi.ModTime())
+}
+
+// recordThumb stamps thumbPath with rel's current mtime/size, upserting
+// rel's index entry if this is its first recorded variant.
+func (idx *ThumbIndex) recordThumb(rel string, fi os.FileInfo, thumbPath string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	entry, ok := idx.Entries[rel]
+	if !ok {
+		entry = &IndexEntry{Variants: map[string]VariantStamp{}}
+		if sum, err := sha1File(filepath.Join(imagesDir, rel)); err == nil {
+			entry.SHA1 = sum
+		}
+		idx.Entries[rel] = entry
+	}
+	if entry.Variants == nil {
+		entry.Variants = map[string]VariantStamp{}
+	}
+	entry.Variants[thumbPath] = VariantStamp{ModTime: fi.ModTime(), Size: fi.Size()}
+	idx.save()
+}
+
+// setMetadata records the EXIF-derived metadata for rel, if it has an
+// index entry (i.e. at least one thumbnail has been generated for it).
+func (idx *ThumbIndex) setMetadata(rel string, meta ImageMetadata) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	entry, ok := idx.Entries[rel]
+	if !ok {
+		return
+	}
+	entry.Metadata = meta
+	idx.save()
+}
+
+// metadata returns the recorded metadata for rel, if any.
+func (idx *ThumbIndex) metadata(rel string) (ImageMetadata, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	entry, ok := idx.Entries[rel]
+	if !ok || entry.Metadata.isZero() {
+		return ImageMetadata{}, false
+	}
+	return entry.Metadata, true
+}
+
+// removeMatching deletes, both from disk and from rel's recorded variants,
+// every path for which match returns true — e.g. dropping only a video's
+// HLS segments while leaving its still-frame thumbnail recorded.
+func (idx *ThumbIndex) removeMatching(rel string, match func(path string) bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	entry, ok := idx.Entries[rel]
+	if !ok {
+		return
+	}
+	for p := range entry.Variants {
+		if match(p) {
+			os.Remove(p)
+			delete(entry.Variants, p)
+		}
+	}
+	idx.save()
+}
+
+// remove deletes rel's index entry and every variant file recorded for it.
+func (idx *ThumbIndex) remove(rel string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	entry, ok := idx.Entries[rel]
+	if !ok {
+		return
+	}
+	for p := range entry.Variants {
+		os.Remove(p)
+	}
+	delete(idx.Entries, rel)
+	idx.save()
+}
+
+func sha1File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sweepDeletedSources runs forever, periodically removing index entries
+// (and their variant files) whose source no longer exists on disk.
+func sweepDeletedSources(idx *ThumbIndex) {
+	for {
+		time.Sleep(sweepInterval)
+
+		idx.mu.RLock()
+		var stale []string
+		for rel := range idx.Entries {
+			if _, err := os.Stat(filepath.Join(imagesDir, rel)); os.IsNotExist(err) {
+				stale = append(stale, rel)
+			}
+		}
+		idx.mu.RUnlock()
+
+		for _, rel := range stale {
+			log.Printf("sweeping thumbnails for deleted source: %s", rel)
+			idx.remove(rel)
+		}
+	}
+}
+
+// reindexAll walks imagesDir and rebuilds index entries for every known
+// media file without regenerating any thumbnails, and sweeps entries for
+// sources that no longer exist. Backs the /api/reindex endpoint.
+func reindexAll(idx *ThumbIndex) (int, error) {
+	count := 0
+	err := filepath.Walk(imagesDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if !imageExts[ext] && !videoExts[ext] {
+			return nil
+		}
+		rel, err := filepath.Rel(imagesDir, path)
+		if err != nil {
+			return err
+		}
+
+		idx.mu.Lock()
+		entry, ok := idx.Entries[rel]
+		if !ok {
+			entry = &IndexEntry{Variants: map[string]VariantStamp{}}
+			idx.Entries[rel] = entry
+		}
+		if sum, err := sha1File(path); err == nil {
+			entry.SHA1 = sum
+		}
+		idx.mu.Unlock()
+
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	idx.mu.RLock()
+	var missing []string
+	for rel := range idx.Entries {
+		if _, err := os.Stat(filepath.Join(imagesDir, rel)); os.IsNotExist(err) {
+			missing = append(missing, rel)
+		}
+	}
+	idx.mu.RUnlock()
+	for _, rel := range missing {
+		idx.remove(rel)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return count, idx.save()
+}
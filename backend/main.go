@@ -1,12 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"embed"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"image"
 	_ "image/gif"
-	_ "image/jpeg"
+	"image/jpeg"
 	_ "image/png"
 	"io/fs"
 	"log"
@@ -14,10 +16,14 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/buckket/go-blurhash"
 	"github.com/chai2010/webp"
+	"github.com/rwcarlsen/goexif/exif"
 	"golang.org/x/image/draw"
 	_ "golang.org/x/image/webp"
 )
@@ -29,6 +35,7 @@ const (
 	maxWorkers   = 4
 	listenAddr   = ":9000"
 	webpQuality  = 80
+	jpegQuality  = 85
 	videoQuality = 75
 )
 
@@ -40,11 +47,19 @@ var (
 		".mp4": true, ".mov": true, ".mkv": true, ".webm": true,
 	}
 
+	// thumbSizes is the whitelist of widths clients may request via
+	// /thumbs/<size>/... or ?w=<size>. Anything else is rejected.
+	thumbSizes = []int{150, 300, 600, 1200}
+
 	// Semaphore to limit concurrent thumbnail generation.
 	workerSem = make(chan struct{}, maxWorkers)
 
 	// Per-file mutex to prevent concurrent generation of the same thumbnail.
 	thumbLocks sync.Map // map[string]*sync.Mutex
+
+	// thumbIndex tracks what's been generated for each source file so we
+	// can tell a stale thumbnail from a fresh one without re-decoding.
+	thumbIndex *ThumbIndex
 )
 
 //go:embed all:static
@@ -54,15 +69,41 @@ type ListResponse struct {
 	Directories []string `json:"directories"`
 	Images      []string `json:"images"`
 	Videos      []string `json:"videos"`
+	// AnimatedThumbs parallels Videos: true if an animated WebP preview
+	// (see handleAnimatedThumb) is available for the video at the same index.
+	AnimatedThumbs []bool `json:"animatedThumbs"`
+	// Metadata carries EXIF-derived info for images, keyed by filename (or
+	// by imagesDir-relative path in the view=bydate mode). Only present
+	// once a thumbnail has been generated for that entry.
+	Metadata map[string]ImageMetadata `json:"metadata,omitempty"`
+	// Placeholders carries a Blurhash string per image/video (same keys as
+	// Metadata) for the frontend to render as an instant LQIP background
+	// while the real thumbnail loads.
+	Placeholders map[string]string `json:"placeholders,omitempty"`
 }
 
 func main() {
+	htpasswdPath := flag.String("htpasswd", "", "path to an htpasswd file gating WebDAV writes (reads stay anonymous); empty disables auth")
+	flag.Parse()
+
 	os.MkdirAll(thumbsDir, 0o755)
+	os.MkdirAll(streamDir, 0o755)
+
+	thumbIndex = loadIndex(indexPath)
+	go sweepDeletedSources(thumbIndex)
+
+	davHandler, err := newDavHandler(*htpasswdPath)
+	if err != nil {
+		log.Fatalf("failed to set up WebDAV: %v", err)
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/list", handleList)
+	mux.HandleFunc("/api/reindex", handleReindex)
 	mux.HandleFunc("/thumbs/", handleThumb)
 	mux.HandleFunc("/images/", handleImage)
+	mux.HandleFunc("/stream/", handleStream)
+	mux.Handle("/dav/", davHandler)
 
 	// Serve the embedded frontend SPA.
 	staticFS, _ := fs.Sub(staticFiles, "static")
@@ -85,12 +126,16 @@ func main() {
 	log.Fatal(http.ListenAndServe(listenAddr, handler))
 }
 
-// corsMiddleware sets CORS headers on all responses.
+// corsMiddleware sets CORS headers on all responses. It answers browser
+// CORS preflight OPTIONS requests directly, but lets OPTIONS under /dav/
+// through to the webdav.Handler unanswered — WebDAV clients (Finder,
+// Windows Explorer, rclone) send their own OPTIONS capability probe and
+// expect the DAV/Allow headers it returns, not a CORS preflight response.
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		if r.Method == http.MethodOptions && !strings.HasPrefix(r.URL.Path, "/dav/") {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, DELETE, MOVE, COPY, MKCOL, PROPFIND, PROPPATCH, OPTIONS")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -114,7 +159,47 @@ func safePath(rel string) (string, error) {
 	return cleaned, nil
 }
 
+// isValidThumbSize reports whether w is one of the whitelisted thumbnail widths.
+func isValidThumbSize(w int) bool {
+	for _, s := range thumbSizes {
+		if s == w {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateFormat picks a thumbnail encoding based on the request's Accept
+// header, falling back to JPEG for clients that don't advertise WebP support.
+func negotiateFormat(r *http.Request) string {
+	if strings.Contains(r.Header.Get("Accept"), "image/webp") {
+		return "webp"
+	}
+	return "jpeg"
+}
+
+// formatExt returns the file extension used to store a thumbnail variant.
+func formatExt(format string) string {
+	if format == "jpeg" {
+		return "jpg"
+	}
+	return "webp"
+}
+
+// contentTypeFor returns the MIME type to serve for a thumbnail format.
+func contentTypeFor(format string) string {
+	if format == "jpeg" {
+		return "image/jpeg"
+	}
+	return "image/webp"
+}
+
 func handleList(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("view") == "bydate" {
+		handleListByDate(w, r)
+		return
+	}
+
 	reqPath := r.URL.Query().Get("path")
 	rel, err := safePath(reqPath)
 	if err != nil {
@@ -134,9 +219,12 @@ func handleList(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp := ListResponse{
-		Directories: []string{},
-		Images:      []string{},
-		Videos:      []string{},
+		Directories:    []string{},
+		Images:         []string{},
+		Videos:         []string{},
+		AnimatedThumbs: []bool{},
+		Metadata:       map[string]ImageMetadata{},
+		Placeholders:   map[string]string{},
 	}
 
 	var thumbTargets []string
@@ -157,11 +245,21 @@ func handleList(w http.ResponseWriter, r *http.Request) {
 			thumbTargets = append(thumbTargets, entryRel)
 		} else if videoExts[ext] {
 			resp.Videos = append(resp.Videos, name)
+			// All known video types support animated preview generation.
+			resp.AnimatedThumbs = append(resp.AnimatedThumbs, true)
 			thumbTargets = append(thumbTargets, entryRel)
+		} else {
+			continue
+		}
+		if meta, ok := thumbIndex.metadata(entryRel); ok {
+			resp.Metadata[name] = meta
+			if meta.Blurhash != "" {
+				resp.Placeholders[name] = meta.Blurhash
+			}
 		}
 	}
 
-	// Kick off background thumbnail pre-generation.
+	// Kick off background thumbnail pre-generation at the default size/format.
 	for _, t := range thumbTargets {
 		go pregenThumb(t)
 	}
@@ -170,6 +268,100 @@ func handleList(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// handleListByDate serves /api/list?view=bydate[&year=YYYY[&month=MM]],
+// grouping indexed media by EXIF capture date instead of filesystem
+// location. With neither year nor month set it returns the known years as
+// Directories; with year only, the known months within that year; with
+// both, the media captured in that year/month, with Images/Videos entries
+// given as imagesDir-relative paths (since results can span directories).
+func handleListByDate(w http.ResponseWriter, r *http.Request) {
+	year := r.URL.Query().Get("year")
+	month := r.URL.Query().Get("month")
+
+	resp := ListResponse{
+		Directories:    []string{},
+		Images:         []string{},
+		Videos:         []string{},
+		AnimatedThumbs: []bool{},
+		Metadata:       map[string]ImageMetadata{},
+		Placeholders:   map[string]string{},
+	}
+
+	seen := map[string]bool{}
+
+	thumbIndex.mu.RLock()
+	for rel, entry := range thumbIndex.Entries {
+		if entry.Metadata.DateTimeOriginal.IsZero() {
+			continue
+		}
+		entryYear := entry.Metadata.DateTimeOriginal.Format("2006")
+		entryMonth := entry.Metadata.DateTimeOriginal.Format("01")
+
+		switch {
+		case year == "":
+			if !seen[entryYear] {
+				seen[entryYear] = true
+				resp.Directories = append(resp.Directories, entryYear)
+			}
+		case month == "":
+			if entryYear != year || seen[entryMonth] {
+				continue
+			}
+			seen[entryMonth] = true
+			resp.Directories = append(resp.Directories, entryMonth)
+		case entryYear == year && entryMonth == month:
+			ext := strings.ToLower(filepath.Ext(rel))
+			if imageExts[ext] {
+				resp.Images = append(resp.Images, rel)
+			} else if videoExts[ext] {
+				resp.Videos = append(resp.Videos, rel)
+				resp.AnimatedThumbs = append(resp.AnimatedThumbs, true)
+			} else {
+				continue
+			}
+			resp.Metadata[rel] = entry.Metadata
+			if entry.Metadata.Blurhash != "" {
+				resp.Placeholders[rel] = entry.Metadata.Blurhash
+			}
+		}
+	}
+	thumbIndex.mu.RUnlock()
+
+	sort.Strings(resp.Directories)
+	sort.Strings(resp.Images)
+	sort.Sort(videosByName{resp.Videos, resp.AnimatedThumbs})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// videosByName sorts Videos alphabetically while keeping AnimatedThumbs[i]
+// matched to Videos[i], per the ListResponse invariant.
+type videosByName struct {
+	videos []string
+	anim   []bool
+}
+
+func (v videosByName) Len() int      { return len(v.videos) }
+func (v videosByName) Swap(i, j int) {
+	v.videos[i], v.videos[j] = v.videos[j], v.videos[i]
+	v.anim[i], v.anim[j] = v.anim[j], v.anim[i]
+}
+func (v videosByName) Less(i, j int) bool { return v.videos[i] < v.videos[j] }
+
+// handleReindex rebuilds thumbIndex from the files currently under
+// imagesDir, sweeping entries for sources that no longer exist. It does
+// not regenerate any thumbnails itself.
+func handleReindex(w http.ResponseWriter, r *http.Request) {
+	count, err := reindexAll(thumbIndex)
+	if err != nil {
+		http.Error(w, "reindex failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"indexed": count})
+}
+
 func handleImage(w http.ResponseWriter, r *http.Request) {
 	rel := strings.TrimPrefix(r.URL.Path, "/images/")
 	clean, err := safePath(rel)
@@ -181,37 +373,84 @@ func handleImage(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, filepath.Join(imagesDir, clean))
 }
 
+// handleThumb serves a thumbnail variant, generating it on demand.
+//
+// The requested size comes from a "/thumbs/<size>/<path>" prefix or a
+// "?w=<size>" query parameter (the latter wins); unset defaults to
+// thumbWidth. The encoding is chosen from the request's Accept header via
+// negotiateFormat, so WebP-capable clients get WebP and everyone else gets
+// a JPEG sibling variant.
 func handleThumb(w http.ResponseWriter, r *http.Request) {
 	rel := strings.TrimPrefix(r.URL.Path, "/thumbs/")
+
+	if strings.HasPrefix(rel, "anim/") {
+		handleAnimatedThumb(w, r, strings.TrimPrefix(rel, "anim/"))
+		return
+	}
+
+	size := thumbWidth
+	if parts := strings.SplitN(rel, "/", 2); len(parts) == 2 {
+		// Only consume the first segment as a size prefix if it's actually
+		// whitelisted, so a real subdirectory that happens to parse as an
+		// int (e.g. "2024/photo.webp") falls through and stays part of rel.
+		if parsed, err := strconv.Atoi(parts[0]); err == nil && isValidThumbSize(parsed) {
+			size = parsed
+			rel = parts[1]
+		}
+	}
+	if q := r.URL.Query().Get("w"); q != "" {
+		parsed, err := strconv.Atoi(q)
+		if err != nil {
+			http.Error(w, "invalid size", http.StatusBadRequest)
+			return
+		}
+		size = parsed
+	}
+	if !isValidThumbSize(size) {
+		http.Error(w, "unsupported thumbnail size", http.StatusBadRequest)
+		return
+	}
+
 	clean, err := safePath(rel)
 	if err != nil || clean == "" {
 		http.Error(w, "invalid path", http.StatusBadRequest)
 		return
 	}
 
-	thumbPath := filepath.Join(thumbsDir, clean)
+	format := negotiateFormat(r)
+	thumbPath := thumbPathFor(clean, size, format)
 
-	// If thumbnail doesn't exist, find the source and generate synchronously.
-	if _, err := os.Stat(thumbPath); os.IsNotExist(err) {
-		srcRel := webpToSource(clean)
-		if srcRel == "" {
-			http.Error(w, "source file not found", http.StatusNotFound)
-			return
-		}
-		if err := generateThumb(srcRel); err != nil {
+	srcRel := webpToSource(clean)
+	if srcRel == "" {
+		http.Error(w, "source file not found", http.StatusNotFound)
+		return
+	}
+	// Generate synchronously if the thumbnail is missing or the source has
+	// changed since it was last generated (per the on-disk index).
+	if needsRegen(thumbPath, srcRel) {
+		if err := generateThumb(srcRel, size, format); err != nil {
 			log.Printf("thumb generation failed for %s: %v", srcRel, err)
 			http.Error(w, "thumbnail generation failed", http.StatusInternalServerError)
 			return
 		}
 	}
 
-	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-	w.Header().Set("Content-Type", "image/webp")
+	// The body varies by Accept (WebP vs. JPEG) for the same URL, so a
+	// shared cache must key on it too — otherwise it pins whichever format
+	// it saw first and serves that to every client regardless of Accept.
+	// Drop "immutable" accordingly: unlike a content-addressed URL, this one
+	// doesn't change when the thumbnail is regenerated, so a cache that
+	// skips revalidation entirely would also keep serving it past that.
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Cache-Control", "public, max-age=31536000")
+	w.Header().Set("Content-Type", contentTypeFor(format))
 	http.ServeFile(w, r, thumbPath)
 }
 
-// webpToSource finds the actual source file for a .webp thumb path.
-// E.g. "vacation/photo.webp" -> "vacation/photo.jpg" (whichever exists).
+// webpToSource finds the actual source file for a thumb path stripped of its
+// size prefix, by swapping its extension for each known media extension.
+// E.g. "vacation/photo.webp" ->
+// "vacation/photo.jpg" (whichever exists).
 func webpToSource(webpRel string) string {
 	base := strings.TrimSuffix(webpRel, filepath.Ext(webpRel))
 	for ext := range imageExts {
@@ -237,75 +476,109 @@ func webpToSource(webpRel string) string {
 	return ""
 }
 
-// pregenThumb generates a thumbnail in the background if it doesn't exist.
+// pregenThumb generates a default-size WebP thumbnail in the background if
+// it's missing or stale. Other sizes/formats are generated lazily on first
+// request by handleThumb.
 func pregenThumb(rel string) {
-	thumbPath := thumbPathFor(rel)
-	if _, err := os.Stat(thumbPath); err == nil {
-		return // already exists
+	thumbPath := thumbPathFor(rel, thumbWidth, "webp")
+	if !needsRegen(thumbPath, rel) {
+		return
 	}
 	workerSem <- struct{}{}
 	defer func() { <-workerSem }()
-	if err := generateThumb(rel); err != nil {
+	if err := generateThumb(rel, thumbWidth, "webp"); err != nil {
 		log.Printf("background thumb generation failed for %s: %v", rel, err)
 	}
 }
 
-// generateThumb generates a thumbnail for the given source path (relative to imagesDir).
-// Uses per-file locking to prevent duplicate work.
-func generateThumb(rel string) error {
-	thumbPath := thumbPathFor(rel)
+// needsRegen reports whether thumbPath is missing, or stale relative to
+// srcRel's current mtime/size per thumbIndex.
+func needsRegen(thumbPath, srcRel string) bool {
+	if _, err := os.Stat(thumbPath); os.IsNotExist(err) {
+		return true
+	}
+	fi, err := os.Stat(filepath.Join(imagesDir, srcRel))
+	if err != nil {
+		return true
+	}
+	return !thumbIndex.fresh(thumbPath, srcRel, fi)
+}
+
+// generateThumb generates a thumbnail variant for the given source path
+// (relative to imagesDir) at the given width and format, and records it in
+// thumbIndex. Uses per-file locking to prevent duplicate work.
+func generateThumb(rel string, width int, format string) error {
+	thumbPath := thumbPathFor(rel, width, format)
 
 	mu, _ := thumbLocks.LoadOrStore(thumbPath, &sync.Mutex{})
 	mu.(*sync.Mutex).Lock()
 	defer mu.(*sync.Mutex).Unlock()
 
-	// Re-check after acquiring lock — another goroutine may have just created it.
-	if _, err := os.Stat(thumbPath); err == nil {
+	// Re-check after acquiring lock — another goroutine may have just
+	// regenerated it while we were waiting.
+	if !needsRegen(thumbPath, rel) {
 		return nil
 	}
-	return doGenerateThumb(rel, thumbPath)
+	meta, err := doGenerateThumb(rel, thumbPath, width, format)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(filepath.Join(imagesDir, rel)); err == nil {
+		thumbIndex.recordThumb(rel, fi, thumbPath)
+		if !meta.isZero() {
+			thumbIndex.setMetadata(rel, meta)
+		}
+	}
+	return nil
 }
 
-// thumbPathFor returns the thumbnail file path for a source file.
-func thumbPathFor(rel string) string {
+// thumbPathFor returns the thumbnail file path for a source file at the
+// given width and format, e.g. "thumbs/600/vacation/photo.webp".
+func thumbPathFor(rel string, width int, format string) string {
 	ext := filepath.Ext(rel)
 	base := strings.TrimSuffix(rel, ext)
-	return filepath.Join(thumbsDir, base+".webp")
+	return filepath.Join(thumbsDir, strconv.Itoa(width), base+"."+formatExt(format))
 }
 
-func doGenerateThumb(rel, thumbPath string) error {
+// doGenerateThumb generates the thumbnail and returns the metadata
+// extracted along the way: EXIF fields, dimensions and Blurhash for images;
+// frame dimensions and Blurhash for videos.
+func doGenerateThumb(rel, thumbPath string, width int, format string) (ImageMetadata, error) {
 	srcPath := filepath.Join(imagesDir, rel)
 	ext := strings.ToLower(filepath.Ext(rel))
 
 	os.MkdirAll(filepath.Dir(thumbPath), 0o755)
 
 	if videoExts[ext] {
-		return generateVideoThumb(srcPath, thumbPath)
+		return generateVideoThumb(srcPath, thumbPath, width, format)
 	}
-	return generateImageThumb(srcPath, thumbPath)
+	return generateImageThumb(srcPath, thumbPath, width, format)
 }
 
-func generateImageThumb(srcPath, thumbPath string) error {
-	f, err := os.Open(srcPath)
+func generateImageThumb(srcPath, thumbPath string, width int, format string) (ImageMetadata, error) {
+	data, err := os.ReadFile(srcPath)
 	if err != nil {
-		return fmt.Errorf("open source: %w", err)
+		return ImageMetadata{}, fmt.Errorf("read source: %w", err)
 	}
-	defer f.Close()
 
-	src, _, err := image.Decode(f)
+	src, _, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
-		return fmt.Errorf("decode image: %w", err)
+		return ImageMetadata{}, fmt.Errorf("decode image: %w", err)
 	}
 
+	orientation, meta := readExif(data)
+	src = applyOrientation(src, orientation)
+
 	bounds := src.Bounds()
 	srcW := bounds.Dx()
 	srcH := bounds.Dy()
 	if srcW == 0 {
-		return fmt.Errorf("source image has zero width")
+		return ImageMetadata{}, fmt.Errorf("source image has zero width")
 	}
+	meta.Width, meta.Height = srcW, srcH
 
-	dstW := thumbWidth
-	dstH := srcH * thumbWidth / srcW
+	dstW := width
+	dstH := srcH * width / srcW
 	if dstH == 0 {
 		dstH = 1
 	}
@@ -313,36 +586,225 @@ func generateImageThumb(srcPath, thumbPath string) error {
 	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
 	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
 
+	// Derived from the already-scaled thumbnail rather than the
+	// full-resolution decode, so this needs no extra decode step.
+	if hash, err := blurhash.Encode(4, 3, dst); err == nil {
+		meta.Blurhash = hash
+	}
+
 	out, err := os.Create(thumbPath)
 	if err != nil {
-		return fmt.Errorf("create thumb file: %w", err)
+		return ImageMetadata{}, fmt.Errorf("create thumb file: %w", err)
 	}
 	defer out.Close()
 
-	if err := webp.Encode(out, dst, &webp.Options{Quality: webpQuality}); err != nil {
-		os.Remove(thumbPath)
-		return fmt.Errorf("encode webp: %w", err)
+	if format == "jpeg" {
+		if err := jpeg.Encode(out, dst, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			os.Remove(thumbPath)
+			return ImageMetadata{}, fmt.Errorf("encode jpeg: %w", err)
+		}
+	} else {
+		if err := webp.Encode(out, dst, &webp.Options{Quality: webpQuality}); err != nil {
+			os.Remove(thumbPath)
+			return ImageMetadata{}, fmt.Errorf("encode webp: %w", err)
+		}
 	}
 	log.Printf("generated thumb: %s", thumbPath)
-	return nil
+	return meta, nil
 }
 
-func generateVideoThumb(srcPath, thumbPath string) error {
+// readExif parses EXIF from a JPEG's raw bytes, returning the orientation
+// tag (1 if absent/unparseable, meaning no transform needed) and whatever
+// metadata it could extract. Non-JPEG sources or files without EXIF simply
+// yield orientation 1 and a mostly-empty ImageMetadata.
+func readExif(data []byte) (orientation int, meta ImageMetadata) {
+	orientation = 1
+
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return orientation, meta
+	}
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if o, err := tag.Int(0); err == nil {
+			orientation = o
+		}
+	}
+	if t, err := x.DateTime(); err == nil {
+		meta.DateTimeOriginal = t
+	}
+	if lat, lng, err := x.LatLong(); err == nil {
+		meta.GPSLat, meta.GPSLng = lat, lng
+	}
+	if tag, err := x.Get(exif.Model); err == nil {
+		if s, err := tag.StringVal(); err == nil {
+			meta.Camera = s
+		}
+	}
+	return orientation, meta
+}
+
+// applyOrientation returns img transformed according to the EXIF
+// orientation tag (values 2-8; 1 or anything out of range is a no-op).
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 { // 5,6,7,8 rotate 90/270, swapping dimensions
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // flip horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // flip vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 CW
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 270 CW
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+func generateVideoThumb(srcPath, thumbPath string, width int, format string) (ImageMetadata, error) {
+	outFormat := "webp"
+	quality := videoQuality
+	if format == "jpeg" {
+		outFormat = "mjpeg"
+	}
 	cmd := exec.Command("ffmpeg",
 		"-i", srcPath,
 		"-vframes", "1",
 		"-ss", "1",
-		"-vf", fmt.Sprintf("scale=%d:-1", thumbWidth),
-		"-f", "webp",
-		"-quality", fmt.Sprintf("%d", videoQuality),
+		"-vf", fmt.Sprintf("scale=%d:-1", width),
+		"-f", outFormat,
+		"-quality", fmt.Sprintf("%d", quality),
 		"-y",
 		thumbPath,
 	)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		os.Remove(thumbPath)
-		return fmt.Errorf("ffmpeg: %w\n%s", err, string(output))
+		return ImageMetadata{}, fmt.Errorf("ffmpeg: %w\n%s", err, string(output))
 	}
 	log.Printf("generated video thumb: %s", thumbPath)
+
+	// Derive the placeholder from the frame we just extracted, rather
+	// than re-invoking ffmpeg, by decoding the still we already wrote.
+	var meta ImageMetadata
+	if f, err := os.Open(thumbPath); err == nil {
+		defer f.Close()
+		if frame, _, err := image.Decode(f); err == nil {
+			b := frame.Bounds()
+			meta.Width, meta.Height = b.Dx(), b.Dy()
+			if hash, err := blurhash.Encode(4, 3, frame); err == nil {
+				meta.Blurhash = hash
+			}
+		}
+	}
+	return meta, nil
+}
+
+const (
+	animDuration = 3 // seconds
+	animFPS      = 10
+)
+
+// handleAnimatedThumb serves a short looping animated WebP preview for a
+// video, generating it on demand. Mounted at /thumbs/anim/<path>.webp.
+func handleAnimatedThumb(w http.ResponseWriter, r *http.Request, rel string) {
+	clean, err := safePath(rel)
+	if err != nil || clean == "" {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	animPath := animThumbPathFor(clean)
+
+	srcRel := webpToSource(clean)
+	if srcRel == "" {
+		http.Error(w, "source file not found", http.StatusNotFound)
+		return
+	}
+	if ext := strings.ToLower(filepath.Ext(srcRel)); !videoExts[ext] {
+		http.Error(w, "animated thumbnails are only available for videos", http.StatusBadRequest)
+		return
+	}
+	if needsRegen(animPath, srcRel) {
+		if err := generateAnimatedThumb(srcRel, animPath); err != nil {
+			log.Printf("animated thumb generation failed for %s: %v", srcRel, err)
+			http.Error(w, "thumbnail generation failed", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Content-Type", "image/webp")
+	http.ServeFile(w, r, animPath)
+}
+
+// animThumbPathFor returns the animated thumbnail path for a source file,
+// e.g. "thumbs/anim/vacation/clip.webp".
+func animThumbPathFor(rel string) string {
+	ext := filepath.Ext(rel)
+	base := strings.TrimSuffix(rel, ext)
+	return filepath.Join(thumbsDir, "anim", base+".webp")
+}
+
+// generateAnimatedThumb renders a short, silent, looping WebP preview
+// starting at the beginning of the source video (rel, relative to
+// imagesDir) and records it in thumbIndex. Uses the same per-file locking
+// scheme as generateThumb to avoid duplicate ffmpeg invocations.
+func generateAnimatedThumb(rel, thumbPath string) error {
+	mu, _ := thumbLocks.LoadOrStore(thumbPath, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	if !needsRegen(thumbPath, rel) {
+		return nil
+	}
+
+	srcPath := filepath.Join(imagesDir, rel)
+	os.MkdirAll(filepath.Dir(thumbPath), 0o755)
+
+	cmd := exec.Command("ffmpeg",
+		"-ss", "0",
+		"-t", fmt.Sprintf("%d", animDuration),
+		"-i", srcPath,
+		"-vf", fmt.Sprintf("fps=%d,scale=%d:-1", animFPS, thumbWidth),
+		"-loop", "0",
+		"-an",
+		"-vcodec", "libwebp",
+		"-lossless", "0",
+		"-q:v", fmt.Sprintf("%d", videoQuality),
+		"-y",
+		thumbPath,
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Remove(thumbPath)
+		return fmt.Errorf("ffmpeg: %w\n%s", err, string(output))
+	}
+	if fi, err := os.Stat(srcPath); err == nil {
+		thumbIndex.recordThumb(rel, fi, thumbPath)
+	}
+	log.Printf("generated animated thumb: %s", thumbPath)
 	return nil
 }
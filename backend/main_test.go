@@ -0,0 +1,100 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"sort"
+	"testing"
+)
+
+// refImage builds a small RGBA test image where each pixel's color encodes
+// its own (x, y) coordinate, so a transform can be checked by comparing the
+// coordinates it claims to have moved a pixel to/from.
+func refImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0, A: 255})
+		}
+	}
+	return img
+}
+
+func pixelAt(img image.Image, x, y int) (int, int) {
+	r, g, _, _ := img.At(x, y).RGBA()
+	return int(r >> 8), int(g >> 8)
+}
+
+func TestApplyOrientation(t *testing.T) {
+	const w, h = 4, 3
+	src := refImage(w, h)
+
+	tests := []struct {
+		orientation int
+		wantW, wantH int
+		// dst maps a source (x, y) to the expected destination (dx, dy).
+		dst func(x, y int) (int, int)
+	}{
+		{1, w, h, func(x, y int) (int, int) { return x, y }},
+		{2, w, h, func(x, y int) (int, int) { return w - 1 - x, y }},
+		{3, w, h, func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }},
+		{4, w, h, func(x, y int) (int, int) { return x, h - 1 - y }},
+		{5, h, w, func(x, y int) (int, int) { return y, x }},
+		{6, h, w, func(x, y int) (int, int) { return h - 1 - y, x }},
+		{7, h, w, func(x, y int) (int, int) { return h - 1 - y, w - 1 - x }},
+		{8, h, w, func(x, y int) (int, int) { return y, w - 1 - x }},
+		{0, w, h, func(x, y int) (int, int) { return x, y }},  // out of range: no-op
+		{9, w, h, func(x, y int) (int, int) { return x, y }},  // out of range: no-op
+	}
+
+	for _, tt := range tests {
+		got := applyOrientation(src, tt.orientation)
+		b := got.Bounds()
+		if b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+			t.Errorf("orientation %d: got size %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+			continue
+		}
+		if tt.orientation == 1 || tt.orientation == 0 || tt.orientation == 9 {
+			// No-op cases return the source image unchanged.
+			continue
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				dx, dy := tt.dst(x, y)
+				gotX, gotY := pixelAt(got, dx, dy)
+				if gotX != x || gotY != y {
+					t.Errorf("orientation %d: dst(%d,%d) = src(%d,%d), want src(%d,%d)", tt.orientation, dx, dy, gotX, gotY, x, y)
+				}
+			}
+		}
+	}
+}
+
+func TestVideosByNameKeepsAnimatedThumbsAligned(t *testing.T) {
+	videos := []string{"c.mp4", "a.mp4", "b.mp4"}
+	anim := []bool{false, true, false} // only "a.mp4" is true, so a misaligned swap is detectable
+
+	sort.Sort(videosByName{videos, anim})
+
+	wantVideos := []string{"a.mp4", "b.mp4", "c.mp4"}
+	wantAnim := map[string]bool{"a.mp4": true, "b.mp4": false, "c.mp4": false}
+
+	for i, v := range videos {
+		if v != wantVideos[i] {
+			t.Fatalf("videos = %v, want %v", videos, wantVideos)
+		}
+		if anim[i] != wantAnim[v] {
+			t.Errorf("anim[%d] (video %q) = %v, want %v", i, v, anim[i], wantAnim[v])
+		}
+	}
+}
+
+func TestReadExifNoData(t *testing.T) {
+	orientation, meta := readExif([]byte("not a jpeg"))
+	if orientation != 1 {
+		t.Errorf("orientation = %d, want 1 for undecodable data", orientation)
+	}
+	if !meta.isZero() {
+		t.Errorf("meta = %+v, want zero value for undecodable data", meta)
+	}
+}
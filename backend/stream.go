@@ -0,0 +1,217 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	streamDir           = "./stream"
+	hlsSegmentSeconds   = 6
+	streamCacheCapacity = 20 // max number of distinct videos kept transcoded at once
+)
+
+// streams tracks which source videos currently have an HLS cache on disk,
+// most-recently-used first, so the cache can be capped at
+// streamCacheCapacity entries.
+var streams = &streamLRU{order: list.New(), elements: map[string]*list.Element{}}
+
+type streamLRU struct {
+	mu       sync.Mutex
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+// touch marks rel as most recently used, evicting the least-recently-used
+// entry (in the background) if the cache is now over capacity.
+func (s *streamLRU) touch(rel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.elements[rel]; ok {
+		s.order.MoveToFront(el)
+		return
+	}
+	s.elements[rel] = s.order.PushFront(rel)
+
+	if s.order.Len() <= streamCacheCapacity {
+		return
+	}
+	oldest := s.order.Back()
+	s.order.Remove(oldest)
+	evicted := oldest.Value.(string)
+	delete(s.elements, evicted)
+	go evictStream(evicted)
+}
+
+// evictStream drops the HLS cache directory for rel and forgets its
+// segment files in thumbIndex, leaving the video's other thumbnail
+// variants (still frame, animated preview) untouched.
+func evictStream(rel string) {
+	log.Printf("evicting HLS cache for %s", rel)
+	os.RemoveAll(streamDirFor(rel))
+	// Recorded paths come from filepath.Join, which cleans away the "./"
+	// in streamDir, so compare against the cleaned form.
+	prefix := filepath.Clean(streamDir) + string(filepath.Separator)
+	thumbIndex.removeMatching(rel, func(p string) bool {
+		return strings.HasPrefix(p, prefix)
+	})
+}
+
+// streamDirFor returns the HLS segment cache directory for a source file.
+func streamDirFor(rel string) string {
+	ext := filepath.Ext(rel)
+	base := strings.TrimSuffix(rel, ext)
+	return filepath.Join(streamDir, base)
+}
+
+// segMarker separates a stream path's source portion from its segment
+// filename, e.g. "vacation/clip/seg-0.ts" -> source "vacation/clip",
+// segment "0.ts".
+const segMarker = "/seg-"
+
+// segFilePattern matches exactly the segment filenames ffmpeg writes
+// ("seg-%d.ts" in transcodeHLS, with the "seg-" stripped). Anything else —
+// including a "/" or ".." that could escape the stream directory — is
+// rejected rather than handed to filepath.Join/ServeFile.
+var segFilePattern = regexp.MustCompile(`^[0-9]+\.ts$`)
+
+// parseStreamPath splits a /stream/-relative path into the source's rel
+// path and, for a segment request, its bare segment filename (segFile is
+// "" for a playlist request). ok is false for anything else, including a
+// segment filename that doesn't match segFilePattern.
+func parseStreamPath(rel string) (srcRel, segFile string, ok bool) {
+	switch {
+	case strings.HasSuffix(rel, "/index.m3u8"):
+		return strings.TrimSuffix(rel, "/index.m3u8"), "", true
+	case strings.Contains(rel, segMarker) && strings.HasSuffix(rel, ".ts"):
+		cut := strings.LastIndex(rel, segMarker)
+		segFile := rel[cut+len(segMarker):]
+		if !segFilePattern.MatchString(segFile) {
+			return "", "", false
+		}
+		return rel[:cut], segFile, true
+	default:
+		return "", "", false
+	}
+}
+
+// handleStream serves HLS playlists and segments for videos browsers can't
+// play natively (.mkv, .mov, ...), transcoding on first request. Mounted at
+// /stream/<path>/index.m3u8 and /stream/<path>/seg-N.ts. The manifest must
+// live at .../index.m3u8 (not /stream/<path>.m3u8) because its segment
+// URIs are bare "seg-N.ts", which a client resolves relative to the
+// manifest's own directory — that directory has to be /stream/<path>/ for
+// the resolved segment URL to land on the seg-N.ts route below.
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	rel := strings.TrimPrefix(r.URL.Path, "/stream/")
+
+	srcRel, segFile, ok := parseStreamPath(rel)
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	clean, err := safePath(srcRel)
+	if err != nil || clean == "" {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	// Reuse the thumbnail lookup's ext-swap logic: strip the synthetic
+	// ".m3u8" suffix and try each known video extension in its place.
+	videoRel := webpToSource(clean + ".m3u8")
+	if videoRel == "" {
+		http.Error(w, "source file not found", http.StatusNotFound)
+		return
+	}
+	if ext := strings.ToLower(filepath.Ext(videoRel)); !videoExts[ext] {
+		http.Error(w, "streaming is only available for videos", http.StatusBadRequest)
+		return
+	}
+
+	streams.touch(videoRel)
+
+	dir := streamDirFor(videoRel)
+	playlistPath := filepath.Join(dir, "index.m3u8")
+
+	if _, err := os.Stat(playlistPath); os.IsNotExist(err) {
+		if err := transcodeHLS(videoRel, dir, playlistPath); err != nil {
+			log.Printf("HLS transcode failed for %s: %v", videoRel, err)
+			http.Error(w, "transcoding failed", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if segFile == "" {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		http.ServeFile(w, r, playlistPath)
+		return
+	}
+
+	segPath := filepath.Join(dir, "seg-"+segFile)
+	if _, err := os.Stat(segPath); err != nil {
+		http.Error(w, "segment not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "video/MP2T")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeFile(w, r, segPath)
+}
+
+// transcodeHLS bounds concurrent transcodes via workerSem, locks per
+// playlist to avoid duplicate ffmpeg runs, and segments rel (relative to
+// imagesDir) into dir as index.m3u8 + seg-N.ts chunks. Every resulting
+// file is recorded in thumbIndex alongside the video's other thumbnail
+// variants, so it's swept away if the source is ever deleted.
+func transcodeHLS(rel, dir, playlistPath string) error {
+	mu, _ := thumbLocks.LoadOrStore(playlistPath, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	if _, err := os.Stat(playlistPath); err == nil {
+		return nil
+	}
+
+	workerSem <- struct{}{}
+	defer func() { <-workerSem }()
+
+	os.MkdirAll(dir, 0o755)
+
+	cmd := exec.Command("ffmpeg",
+		"-i", filepath.Join(imagesDir, rel),
+		"-c:v", "libx264",
+		"-c:a", "aac",
+		"-f", "hls",
+		"-hls_time", fmt.Sprintf("%d", hlsSegmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(dir, "seg-%d.ts"),
+		"-y",
+		playlistPath,
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("ffmpeg: %w\n%s", err, string(output))
+	}
+
+	fi, err := os.Stat(filepath.Join(imagesDir, rel))
+	if err != nil {
+		return nil
+	}
+	thumbIndex.recordThumb(rel, fi, playlistPath)
+	segs, _ := filepath.Glob(filepath.Join(dir, "seg-*.ts"))
+	for _, seg := range segs {
+		thumbIndex.recordThumb(rel, fi, seg)
+	}
+	log.Printf("transcoded HLS stream: %s (%d segments)", playlistPath, len(segs))
+	return nil
+}
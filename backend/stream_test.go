@@ -0,0 +1,77 @@
+package main
+
+import "testing"
+
+func TestParseStreamPath(t *testing.T) {
+	tests := []struct {
+		name        string
+		rel         string
+		wantSrcRel  string
+		wantSegFile string
+		wantOK      bool
+	}{
+		{
+			name:       "playlist",
+			rel:        "vacation/clip/index.m3u8",
+			wantSrcRel: "vacation/clip",
+			wantOK:     true,
+		},
+		{
+			name:        "segment",
+			rel:         "vacation/clip/seg-0.ts",
+			wantSrcRel:  "vacation/clip",
+			wantSegFile: "0.ts",
+			wantOK:      true,
+		},
+		{
+			name:        "segment with multi-digit index",
+			rel:         "a/b/c/seg-123.ts",
+			wantSrcRel:  "a/b/c",
+			wantSegFile: "123.ts",
+			wantOK:      true,
+		},
+		{
+			name:   "neither suffix",
+			rel:    "vacation/clip/thumb.webp",
+			wantOK: false,
+		},
+		{
+			name:   "ts suffix without seg marker",
+			rel:    "vacation/clip/notasegment.ts",
+			wantOK: false,
+		},
+		{
+			name:   "segment filename with path traversal",
+			rel:    "vacation/clip/seg-../../../etc/passwd.ts",
+			wantOK: false,
+		},
+		{
+			name:   "segment filename with slash",
+			rel:    "vacation/clip/seg-sub/0.ts",
+			wantOK: false,
+		},
+		{
+			name:   "non-numeric segment filename",
+			rel:    "vacation/clip/seg-evil.ts",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcRel, segFile, ok := parseStreamPath(tt.rel)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if srcRel != tt.wantSrcRel {
+				t.Errorf("srcRel = %q, want %q", srcRel, tt.wantSrcRel)
+			}
+			if segFile != tt.wantSegFile {
+				t.Errorf("segFile = %q, want %q", segFile, tt.wantSegFile)
+			}
+		})
+	}
+}
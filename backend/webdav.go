@@ -0,0 +1,153 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/webdav"
+)
+
+// newDavHandler builds the /dav/ WebDAV handler rooted at imagesDir. Read
+// methods (GET, HEAD, OPTIONS, PROPFIND) stay anonymous; write methods
+// require HTTP Basic Auth against htpasswdPath when it's non-empty.
+// Successful writes also invalidate any cached thumbnails for the
+// affected source file.
+func newDavHandler(htpasswdPath string) (http.Handler, error) {
+	var creds *htpasswdFile
+	if htpasswdPath != "" {
+		loaded, err := loadHtpasswd(htpasswdPath)
+		if err != nil {
+			return nil, err
+		}
+		creds = loaded
+	}
+
+	handler := &webdav.Handler{
+		Prefix:     "/dav/",
+		FileSystem: webdav.Dir(imagesDir),
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Printf("webdav %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+
+	return davInvalidationMiddleware(davWriteGate(handler, creds)), nil
+}
+
+// davWriteGate requires HTTP Basic Auth for WebDAV write methods when
+// creds is non-nil; read methods always pass through anonymously.
+func davWriteGate(next http.Handler, creds *htpasswdFile) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if creds == nil || !isDavWriteMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, pass, ok := r.BasicAuth()
+		if !ok || !creds.verify(user, pass) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gallery"`)
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isDavWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPut, http.MethodDelete, http.MethodPost,
+		"MOVE", "COPY", "MKCOL", "PROPPATCH":
+		return true
+	}
+	return false
+}
+
+// davInvalidationMiddleware drops the cached thumbnails (and index entry)
+// for any source touched by a PUT/DELETE/MOVE, so stale thumbs aren't
+// served after an upload, delete, or rename made via WebDAV. Invalidation
+// only runs if the request actually succeeded — otherwise an unauthenticated
+// or otherwise-rejected write (davWriteGate's 401, webdav's own 4xx) would
+// still wipe a source's cached thumbnails, metadata and sha1 for no reason.
+func davInvalidationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusCapturingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		if sw.status < 200 || sw.status >= 300 {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPut, http.MethodDelete:
+			invalidateDavPath(r.URL.Path)
+		case "MOVE":
+			invalidateDavPath(r.URL.Path)
+			if dest := r.Header.Get("Destination"); dest != "" {
+				if u, err := url.Parse(dest); err == nil {
+					invalidateDavPath(u.Path)
+				}
+			}
+		}
+	})
+}
+
+// statusCapturingWriter records the status code passed to WriteHeader (or
+// the implicit 200 if the handler never calls it) so a wrapping middleware
+// can inspect it after the handler returns.
+type statusCapturingWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusCapturingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func invalidateDavPath(urlPath string) {
+	rel := strings.TrimPrefix(urlPath, "/dav/")
+	clean, err := safePath(rel)
+	if err != nil || clean == "" {
+		return
+	}
+	thumbIndex.remove(clean)
+}
+
+// htpasswdFile is a minimal reader for Apache-style htpasswd files.
+// Only bcrypt hashes ($2a$/$2b$/$2y$, as produced by `htpasswd -B`) are
+// supported; other hash formats are treated as non-matching.
+type htpasswdFile struct {
+	users map[string]string // username -> bcrypt hash
+}
+
+func loadHtpasswd(path string) (*htpasswdFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	h := &htpasswdFile{users: map[string]string{}}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		h.users[parts[0]] = parts[1]
+	}
+	return h, nil
+}
+
+func (h *htpasswdFile) verify(user, pass string) bool {
+	hash, ok := h.users[user]
+	if !ok || !strings.HasPrefix(hash, "$2") {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+}